@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCramMD5Response(t *testing.T) {
+	challenge := "<12345.1234567890@mesos-master>"
+	encodedChallenge := base64.StdEncoding.EncodeToString([]byte(challenge))
+	principal := "framework1"
+	secret := "s3cr3t"
+
+	got, err := cramMD5Response("CRAM-MD5 "+encodedChallenge, principal, secret)
+	if err != nil {
+		t.Fatalf("cramMD5Response: unexpected error: %v", err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(got[len("CRAM-MD5 "):])
+	if err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write([]byte(challenge))
+	wantDigest := hex.EncodeToString(mac.Sum(nil))
+	wantResponse := principal + " " + wantDigest
+
+	if string(decoded) != wantResponse {
+		t.Errorf("cramMD5Response decoded = %q, want %q", decoded, wantResponse)
+	}
+}
+
+func TestCramMD5ResponseInvalidChallenge(t *testing.T) {
+	_, err := cramMD5Response("CRAM-MD5 not-valid-base64!!", "framework1", "s3cr3t")
+	if err == nil {
+		t.Fatal("cramMD5Response: expected error for malformed base64 challenge, got nil")
+	}
+}
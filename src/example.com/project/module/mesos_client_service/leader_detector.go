@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// LeaderDetector abstracts how the current mesos master is discovered, so
+// the polling loop can swap between following the legacy 'leader' field in
+// /state and watching Zookeeper directly for the elected master.
+type LeaderDetector interface {
+	// Leader returns the current master's host:port.
+	Leader() (string, error)
+	// LeaderChanged delivers the new master's host:port every time the
+	// elected leader changes. Detectors that cannot observe changes (eg.
+	// the plain seed list) may return a nil channel.
+	LeaderChanged() <-chan string
+	// Close releases any resources held by the detector.
+	Close()
+}
+
+// seedListDetector is the fallback LeaderDetector used when no --zk
+// connection string is given. It cycles through a comma-separated list of
+// master host:port seeds, falling back to the next one when the current
+// seed is unreachable, but cannot react to leader changes on its own.
+type seedListDetector struct {
+	seeds []string
+}
+
+func newSeedListDetector(seeds []string) *seedListDetector {
+	return &seedListDetector{seeds: seeds}
+}
+
+func (d *seedListDetector) Leader() (string, error) {
+	for _, seed := range d.seeds {
+		conn, err := net.DialTimeout("tcp", seed, 3*time.Second)
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		return seed, nil
+	}
+	return "", fmt.Errorf("no reachable master found in seed list %v", d.seeds)
+}
+
+func (d *seedListDetector) LeaderChanged() <-chan string {
+	return nil
+}
+
+func (d *seedListDetector) Close() {}
+
+// zkMasterInfo mirrors the fields we care about in the MasterInfo protobuf
+// that mesos serializes as JSON into each master's znode, eg.
+// '{"address":{"hostname":"172.31.43.147","port":5050},"pid":"master@172.31.43.147:5050"}'
+type zkMasterInfo struct {
+	Address struct {
+		Hostname string `json:"hostname"`
+		Port     int    `json:"port"`
+	} `json:"address"`
+	Pid string `json:"pid"`
+}
+
+// zkMesosMasterPrefix is the znode name prefix mesos uses for the
+// sequential ephemeral znodes it registers under the configured chroot, eg.
+// /mesos/json.info_0000000042. The lowest sequence number is the leader.
+const zkMesosMasterPrefix = "json.info_"
+
+// zkLeaderDetector watches the mesos master znodes under a Zookeeper
+// chroot and reports the elected leader, reacting to ZK watch events
+// instead of polling /state for the 'leader' field.
+type zkLeaderDetector struct {
+	conn    *zk.Conn
+	path    string
+	changed chan string
+	done    chan struct{}
+}
+
+// newZKLeaderDetector connects to the Zookeeper ensemble encoded in zkURL
+// (eg. "zk://host1:2181,host2:2181/mesos") and starts watching for leader
+// changes under the given chroot.
+func newZKLeaderDetector(zkURL string) (*zkLeaderDetector, error) {
+	servers, path, err := parseZKURL(zkURL)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := zk.Connect(servers, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	d := &zkLeaderDetector{
+		conn:    conn,
+		path:    path,
+		changed: make(chan string, 1),
+		done:    make(chan struct{}),
+	}
+	initialLeader, err := d.resolve()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go d.watch(initialLeader)
+	return d, nil
+}
+
+func parseZKURL(zkURL string) (servers []string, path string, err error) {
+	if !strings.HasPrefix(zkURL, "zk://") {
+		return nil, "", errors.New("zk url must be of the form zk://host1:2181,host2:2181/mesos")
+	}
+	rest := strings.TrimPrefix(zkURL, "zk://")
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, "", errors.New("zk url must include a chroot path, eg. zk://host:2181/mesos")
+	}
+	return strings.Split(parts[0], ","), "/" + parts[1], nil
+}
+
+// resolve reads the sequential ephemeral znode with the lowest sequence
+// number under d.path and deserializes its MasterInfo JSON payload.
+func (d *zkLeaderDetector) resolve() (string, error) {
+	children, _, err := d.conn.Children(d.path)
+	if err != nil {
+		return "", err
+	}
+	leaderNode := ""
+	lowestSeq := -1
+	for _, child := range children {
+		if !strings.HasPrefix(child, zkMesosMasterPrefix) {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimPrefix(child, zkMesosMasterPrefix))
+		if err != nil {
+			continue
+		}
+		if lowestSeq == -1 || seq < lowestSeq {
+			lowestSeq = seq
+			leaderNode = child
+		}
+	}
+	if leaderNode == "" {
+		return "", fmt.Errorf("no mesos master znodes found under %s", d.path)
+	}
+	data, _, err := d.conn.Get(d.path + "/" + leaderNode)
+	if err != nil {
+		return "", err
+	}
+	var info zkMasterInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", info.Address.Hostname, info.Address.Port), nil
+}
+
+// watch re-reads d.path's children whenever ZK fires a watch event and
+// pushes the resolved leader to d.changed when it differs from before.
+// initialLeader seeds lastLeader with the leader already returned by the
+// constructor's resolve() call, so the first watch event only fires a
+// change if the leader actually moved, not just because some unrelated
+// znode (eg. a standby master registering) changed under the chroot.
+func (d *zkLeaderDetector) watch(initialLeader string) {
+	lastLeader := initialLeader
+	for {
+		_, _, events, err := d.conn.ChildrenW(d.path)
+		if err != nil {
+			select {
+			case <-d.done:
+				return
+			case <-time.After(5 * time.Second):
+				continue
+			}
+		}
+		select {
+		case <-events:
+			hostPort, err := d.resolve()
+			if err != nil {
+				log.Error().Err(err).Msg("failed to resolve mesos leader after zk watch event")
+				continue
+			}
+			if hostPort != lastLeader {
+				lastLeader = hostPort
+				leaderChangesTotal.Inc()
+				select {
+				case d.changed <- hostPort:
+				default:
+				}
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *zkLeaderDetector) Leader() (string, error) {
+	return d.resolve()
+}
+
+func (d *zkLeaderDetector) LeaderChanged() <-chan string {
+	return d.changed
+}
+
+func (d *zkLeaderDetector) Close() {
+	close(d.done)
+	d.conn.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestParseZKURL(t *testing.T) {
+	cases := []struct {
+		name        string
+		zkURL       string
+		wantServers []string
+		wantPath    string
+		wantErr     bool
+	}{
+		{
+			name:        "single server",
+			zkURL:       "zk://host1:2181/mesos",
+			wantServers: []string{"host1:2181"},
+			wantPath:    "/mesos",
+		},
+		{
+			name:        "multiple servers and nested chroot",
+			zkURL:       "zk://host1:2181,host2:2181,host3:2181/mesos/cluster1",
+			wantServers: []string{"host1:2181", "host2:2181", "host3:2181"},
+			wantPath:    "/mesos/cluster1",
+		},
+		{
+			name:    "missing scheme",
+			zkURL:   "host1:2181/mesos",
+			wantErr: true,
+		},
+		{
+			name:    "missing chroot",
+			zkURL:   "zk://host1:2181",
+			wantErr: true,
+		},
+		{
+			name:    "empty chroot",
+			zkURL:   "zk://host1:2181/",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			servers, path, err := parseZKURL(tc.zkURL)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseZKURL(%q): expected error, got servers=%v path=%q", tc.zkURL, servers, path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseZKURL(%q): unexpected error: %v", tc.zkURL, err)
+			}
+			if path != tc.wantPath {
+				t.Errorf("parseZKURL(%q): path = %q, want %q", tc.zkURL, path, tc.wantPath)
+			}
+			if len(servers) != len(tc.wantServers) {
+				t.Fatalf("parseZKURL(%q): servers = %v, want %v", tc.zkURL, servers, tc.wantServers)
+			}
+			for i := range servers {
+				if servers[i] != tc.wantServers[i] {
+					t.Errorf("parseZKURL(%q): servers[%d] = %q, want %q", tc.zkURL, i, servers[i], tc.wantServers[i])
+				}
+			}
+		})
+	}
+}
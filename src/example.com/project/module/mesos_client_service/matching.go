@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Predicate tests whether a single agent matches a selection criterion.
+type Predicate interface {
+	Match(slave MesosSlave) bool
+}
+
+// attrPredicate matches one of the flat MesosSlaveAttributes fields
+// against an exact value, eg. "rack=us-east-1a" or "privateip=172.x.y.z".
+type attrPredicate struct {
+	key   string
+	value string
+}
+
+func (p attrPredicate) Match(slave MesosSlave) bool {
+	switch p.key {
+	case "privateip":
+		return slave.Attributes.PrivateIP == p.value
+	case "publicip":
+		return slave.Attributes.PublicIP == p.value
+	case "rack":
+		return slave.Attributes.Rack == p.value
+	case "instance_type":
+		return slave.Attributes.InstanceType == p.value
+	case "host":
+		return slave.Attributes.Host == p.value
+	default:
+		return false
+	}
+}
+
+type hostnameRegexPredicate struct {
+	re *regexp.Regexp
+}
+
+func (p hostnameRegexPredicate) Match(slave MesosSlave) bool {
+	return p.re.MatchString(slave.Attributes.Host)
+}
+
+type publicIPCIDRPredicate struct {
+	cidr *net.IPNet
+}
+
+func (p publicIPCIDRPredicate) Match(slave MesosSlave) bool {
+	ip := net.ParseIP(slave.Attributes.PublicIP)
+	if ip == nil {
+		return false
+	}
+	return p.cidr.Contains(ip)
+}
+
+// MatchSpec selects a subset of agents out of a MesosState, mirroring the
+// label-based selectors Kubernetes-on-Mesos node controllers use instead
+// of matching on a single IP.
+type MatchSpec struct {
+	predicates []Predicate
+	matchAll   bool // true: AND the predicates (--match-all, the default); false: OR them (--match-any)
+	minCount   int  // --match-count; at least this many agents must match
+}
+
+// Matches reports whether slave satisfies the spec's predicates.
+func (spec MatchSpec) Matches(slave MesosSlave) bool {
+	if len(spec.predicates) == 0 {
+		return true
+	}
+	if spec.matchAll {
+		for _, p := range spec.predicates {
+			if !p.Match(slave) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, p := range spec.predicates {
+		if p.Match(slave) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns every agent in state matching the spec.
+func (spec MatchSpec) Select(state *MesosState) []MesosSlave {
+	var matched []MesosSlave
+	for _, slave := range state.Slaves {
+		if spec.Matches(slave) {
+			matched = append(matched, slave)
+		}
+	}
+	return matched
+}
+
+// requiredCount is the number of matches spec.Select must return to be
+// considered satisfied; --match-count defaults to 1.
+func (spec MatchSpec) requiredCount() int {
+	if spec.minCount < 1 {
+		return 1
+	}
+	return spec.minCount
+}
+
+// buildMatchSpec assembles a MatchSpec from the CLI's --sip/--match-*
+// flags. sip is kept as sugar for an exact privateip attribute predicate,
+// for backward compatibility with the original single-IP behavior.
+func buildMatchSpec(sip, matchAttr, matchHostnameRegex, matchPublicIPCIDR string,
+	matchCount int, matchAny bool) (MatchSpec, error) {
+	var predicates []Predicate
+
+	if sip != "" {
+		predicates = append(predicates, attrPredicate{key: "privateip", value: sip})
+	}
+	if matchAttr != "" {
+		for _, kv := range strings.Split(matchAttr, ",") {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return MatchSpec{}, fmt.Errorf("invalid --match-attr entry %q, expected key=value", kv)
+			}
+			predicates = append(predicates, attrPredicate{key: parts[0], value: parts[1]})
+		}
+	}
+	if matchHostnameRegex != "" {
+		re, err := regexp.Compile(matchHostnameRegex)
+		if err != nil {
+			return MatchSpec{}, fmt.Errorf("invalid --match-hostname-regex: %s", err)
+		}
+		predicates = append(predicates, hostnameRegexPredicate{re: re})
+	}
+	if matchPublicIPCIDR != "" {
+		_, cidr, err := net.ParseCIDR(matchPublicIPCIDR)
+		if err != nil {
+			return MatchSpec{}, fmt.Errorf("invalid --match-public-ip-cidr: %s", err)
+		}
+		predicates = append(predicates, publicIPCIDRPredicate{cidr: cidr})
+	}
+
+	return MatchSpec{predicates: predicates, matchAll: !matchAny, minCount: matchCount}, nil
+}
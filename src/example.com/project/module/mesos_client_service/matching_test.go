@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func slaveWith(rack, instanceType, publicIP string) MesosSlave {
+	return MesosSlave{
+		Attributes: MesosSlaveAttributes{
+			Rack:         rack,
+			InstanceType: instanceType,
+			PublicIP:     publicIP,
+		},
+	}
+}
+
+func TestMatchSpecMatchesAll(t *testing.T) {
+	spec := MatchSpec{
+		predicates: []Predicate{
+			attrPredicate{key: "rack", value: "us-east-1a"},
+			attrPredicate{key: "instance_type", value: "m5.large"},
+		},
+		matchAll: true,
+	}
+
+	matching := slaveWith("us-east-1a", "m5.large", "")
+	if !spec.Matches(matching) {
+		t.Error("Matches: expected true when both predicates are satisfied")
+	}
+
+	partial := slaveWith("us-east-1a", "m5.xlarge", "")
+	if spec.Matches(partial) {
+		t.Error("Matches: expected false when only one predicate is satisfied under match-all")
+	}
+}
+
+func TestMatchSpecMatchesAny(t *testing.T) {
+	spec := MatchSpec{
+		predicates: []Predicate{
+			attrPredicate{key: "rack", value: "us-east-1a"},
+			attrPredicate{key: "instance_type", value: "m5.large"},
+		},
+		matchAll: false,
+	}
+
+	partial := slaveWith("us-east-1a", "m5.xlarge", "")
+	if !spec.Matches(partial) {
+		t.Error("Matches: expected true when one predicate is satisfied under match-any")
+	}
+
+	none := slaveWith("us-west-2a", "m5.xlarge", "")
+	if spec.Matches(none) {
+		t.Error("Matches: expected false when no predicate is satisfied")
+	}
+}
+
+func TestMatchSpecMatchesNoPredicates(t *testing.T) {
+	spec := MatchSpec{}
+	if !spec.Matches(slaveWith("", "", "")) {
+		t.Error("Matches: expected true when no predicates are configured")
+	}
+}
+
+func TestMatchSpecSelect(t *testing.T) {
+	spec := MatchSpec{
+		predicates: []Predicate{attrPredicate{key: "rack", value: "us-east-1a"}},
+		matchAll:   true,
+	}
+	state := &MesosState{
+		Slaves: []MesosSlave{
+			slaveWith("us-east-1a", "m5.large", ""),
+			slaveWith("us-west-2a", "m5.large", ""),
+			slaveWith("us-east-1a", "m5.xlarge", ""),
+		},
+	}
+
+	matched := spec.Select(state)
+	if len(matched) != 2 {
+		t.Fatalf("Select: got %d matches, want 2", len(matched))
+	}
+	for _, slave := range matched {
+		if slave.Attributes.Rack != "us-east-1a" {
+			t.Errorf("Select: matched slave with rack %q, want %q", slave.Attributes.Rack, "us-east-1a")
+		}
+	}
+}
+
+func TestMatchSpecRequiredCount(t *testing.T) {
+	if got := (MatchSpec{}).requiredCount(); got != 1 {
+		t.Errorf("requiredCount with zero minCount = %d, want 1", got)
+	}
+	if got := (MatchSpec{minCount: 3}).requiredCount(); got != 3 {
+		t.Errorf("requiredCount with minCount 3 = %d, want 3", got)
+	}
+}
@@ -5,9 +5,12 @@ package main
 Build Instructions
 ------------------
 From the mesos_http_api folder:
- gofmt -w src/example.com/project/module/mesos_client_service/mesos_client_service.go
- go build -o mesos_http_api src/example.com/project/module/mesos_client_service/mesos_client_service.go
+ gofmt -w src/example.com/project/module/mesos_client_service/*.go
+ go build -o mesos_http_api src/example.com/project/module/mesos_client_service/*.go
  ./mesos_client_service --mip 52.205.254.68 --mport 5050 --mapi "/state" -sip 172.31.34.94
+ ./mesos_client_service --zk zk://10.0.0.1:2181,10.0.0.2:2181/mesos -sip 172.31.34.94
+ ./mesos_client_service --mip 52.205.254.68 --mport 5050 --serve ":8080"
+ ./mesos_client_service --mip 52.205.254.68 --mport 5050 --match-attr "rack=us-east-1a,instance_type=m5.large" --match-count 2 --json
 
 
  CAVEAT: Can only be run from EC2 as mesos registers with private IP (I have
@@ -22,11 +25,12 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 type MesosSlaveAttributes struct {
@@ -69,137 +73,294 @@ type MesosState struct {
 	Slaves []MesosSlave `json:"slaves"`
 }
 
-func queryMesosState(url string) (*MesosState, error) {
-	fmt.Printf("Querying mesos endpoint @:%s\n", url)
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		Dial: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 300 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout: 10 * time.Second,
-	}
-	client := &http.Client{
-		Transport: transport,
-	}
+// queryMesosState speaks the deprecated /state endpoint; kept behind
+// --legacy-api for clusters not yet running a v1 Operator API capable
+// master. Prefer MesosClient.GetMaster()/GetAgents() otherwise. hostPort
+// labels the request metrics with the same bare host:port MesosClient.call()
+// uses, so per-master latency/error rates line up across both APIs.
+func queryMesosState(url, hostPort string, httpClient *http.Client) (*MesosState, error) {
+	log.Debug().Str("url", url).Msg("querying mesos endpoint")
+	start := time.Now()
 	httpReq, err := http.NewRequest("POST", url, strings.NewReader(""))
 	if err != nil {
-		// TODO(Krish): handle panic(err) in code
-		panic(err)
+		return nil, err
 	}
-	httpResp, err := client.Do(httpReq)
+	httpResp, err := httpClient.Do(httpReq)
+	stateRequestDuration.WithLabelValues(hostPort).Observe(time.Since(start).Seconds())
 	if err != nil {
-		// TODO(Krish): handle panic(err) in code
-		panic(err)
+		stateRequestsTotal.WithLabelValues("error", hostPort).Inc()
+		return nil, err
 	}
 	defer httpResp.Body.Close()
-	fmt.Printf("HTTP Response Code: '%s'\n", httpResp.Status)
+	log.Debug().Str("url", url).Str("status", httpResp.Status).Msg("mesos state response")
 	mesosState := new(MesosState)
 	err = json.NewDecoder(httpResp.Body).Decode(mesosState)
 	if err != nil {
+		stateRequestsTotal.WithLabelValues("error", hostPort).Inc()
 		return nil, err
 	}
+	stateRequestsTotal.WithLabelValues("success", hostPort).Inc()
 	return mesosState, nil
 }
 
-func isSlaveRegistered(mesosHostPort, mesosApiEndpoint, agentIP string) bool {
-	url := fmt.Sprintf("http://%s/%s", mesosHostPort, mesosApiEndpoint)
+// queryMesosStateV1 fetches the current master/agent state over the v1
+// Operator API, replacing the legacy path's manual 'leader' field chasing:
+// a non-leading master simply 307-redirects both calls to the leader.
+func queryMesosStateV1(mesosHostPort string, conn mesosConn) (*MesosState, error) {
+	client := NewMesosClient(mesosHostPort, conn)
+	master, err := client.GetMaster()
+	if err != nil {
+		return nil, err
+	}
+	agents, err := client.GetAgents()
+	if err != nil {
+		return nil, err
+	}
+	master.Slaves = agents.Slaves
+	return master, nil
+}
+
+// fetchMesosState fetches a consistent snapshot of the cluster state,
+// following the legacy path's 'leader' field (v1 non-leading masters
+// 307-redirect on their own, see queryMesosStateV1).
+func fetchMesosState(mesosHostPort, mesosApiEndpoint string, legacyAPI bool, conn mesosConn) (*MesosState, error) {
+	stateData, err := fetchMesosStateUncached(mesosHostPort, mesosApiEndpoint, legacyAPI, conn)
+	if err != nil {
+		return nil, err
+	}
+	agentsActive.Set(float64(len(stateData.Slaves)))
+	return stateData, nil
+}
+
+func fetchMesosStateUncached(mesosHostPort, mesosApiEndpoint string, legacyAPI bool, conn mesosConn) (*MesosState, error) {
+	if !legacyAPI {
+		return queryMesosStateV1(mesosHostPort, conn)
+	}
+	url := fmt.Sprintf("%s://%s/%s", conn.scheme, mesosHostPort, mesosApiEndpoint)
 	// query the mesos endpoint to get the data
-	stateData, err := queryMesosState(url)
+	stateData, err := queryMesosState(url, mesosHostPort, conn.httpClient)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
-	//fmt.Printf("elected_time: '%f'\n", stateData.ElectedTime)
-	//fmt.Printf("leader: '%s'\n", stateData.Leader)
-	//fmt.Printf("pid: '%s'\n", stateData.Pid)
+	log.Debug().Float64("elected_time", stateData.ElectedTime).
+		Str("leader", stateData.Leader).Str("pid", stateData.Pid).Msg("fetched mesos state")
 	if stateData.ElectedTime == 0.0 && stateData.Leader != stateData.Pid {
-		//fmt.Printf("Found a non-leader member of mesos cluster\n")
+		log.Debug().Msg("found a non-leader member of mesos cluster, following leader field")
 		// query leader; eg."master@172.31.43.147:5050",
-		newHostPort := strings.Split(stateData.Leader, "@")[1]
-		url = fmt.Sprintf("http://%s/%s", newHostPort, mesosApiEndpoint)
-		//fmt.Printf("Querying discovered mesos leader @'%s'\n", url)
-		stateData, err = queryMesosState(url)
+		parts := strings.SplitN(stateData.Leader, "@", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed leader field %q, expected 'master@host:port'", stateData.Leader)
+		}
+		newHostPort := parts[1]
+		url = fmt.Sprintf("%s://%s/%s", conn.scheme, newHostPort, mesosApiEndpoint)
+		stateData, err = queryMesosState(url, newHostPort, conn.httpClient)
+		if err != nil {
+			return nil, err
+		}
 	} else if stateData.ElectedTime != 0.0 &&
 		stateData.Leader == stateData.Pid {
-		//fmt.Printf("Found a leader of mesos cluster!\n")
+		log.Debug().Msg("found a leader of mesos cluster")
 	}
-	// check if the slave is registered
-	for _, slave := range stateData.Slaves {
-		if slave.Attributes.PrivateIP == agentIP {
-			return true
-		}
+	return stateData, nil
+}
+
+// matchingSlaves fetches the current cluster state and returns the agents
+// satisfying spec.
+func matchingSlaves(mesosHostPort, mesosApiEndpoint string, legacyAPI bool, conn mesosConn, spec MatchSpec) ([]MesosSlave, error) {
+	stateData, err := fetchMesosState(mesosHostPort, mesosApiEndpoint, legacyAPI, conn)
+	if err != nil {
+		return nil, err
 	}
-	return false
+	return spec.Select(stateData), nil
 }
 
-func asyncQueryRegistration(mesosHostPort, mesosApiEndpoint, agentIP string,
-	out chan<- bool, done <-chan bool) {
-	var exitFor bool
-	exitFor = false
+func asyncQueryRegistration(detector LeaderDetector, mesosApiEndpoint string,
+	legacyAPI bool, conn mesosConn, spec MatchSpec, out chan<- []MesosSlave, done <-chan bool) {
+	mesosHostPort, err := detector.Leader()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to resolve initial mesos leader")
+		close(out)
+		return
+	}
+
+	agentEvents := make(chan struct{}, 1)
+	go watchAgentEvents(detector, legacyAPI, conn, agentEvents)
 
 	for {
-		// check whether slave is registered every 15secs
-		isRegistered := isSlaveRegistered(mesosHostPort, mesosApiEndpoint,
-			agentIP)
-		if isRegistered == true {
-			out <- isRegistered
+		// check whether enough agents match every 15secs, immediately after
+		// the leader changes, or immediately after an AGENT_ADDED/AGENT_REMOVED
+		// event arrives over the v1 SUBSCRIBE stream
+		matched, err := matchingSlaves(mesosHostPort, mesosApiEndpoint, legacyAPI, conn, spec)
+		if err != nil {
+			log.Error().Err(err).Str("master", mesosHostPort).Msg("failed to fetch mesos state, falling back to next seed")
+			if newHostPort, resolveErr := detector.Leader(); resolveErr == nil {
+				mesosHostPort = newHostPort
+			}
+		} else if len(matched) >= spec.requiredCount() {
+			out <- matched
 		}
 		select {
 		case <-done:
-			fmt.Printf("Exiting async loop....")
+			log.Info().Msg("exiting async registration poll loop")
 			// signal to stop the routine, close the channels and exit
 			close(out)
-			// break select
-			exitFor = true
-			break
+			return
+		case newHostPort := <-detector.LeaderChanged():
+			log.Info().Str("leader", newHostPort).Msg("mesos leader changed")
+			mesosHostPort = newHostPort
+		case <-agentEvents:
+			// an agent was added/removed; re-query right away
+			continue
 		case <-time.After(time.Second * 15):
 			// continue for, re-query
 			continue
 		}
-
-		if exitFor == true {
-			//break for
-			break
-		}
 	}
 }
 
-func waitForMesosSlaveRegistration(mip, mport, mapi, sip string) bool {
-	// check for a maximum of 5 mins to see if slave has registered
+func waitForMesosSlaveRegistration(detector LeaderDetector, mapi string, legacyAPI bool, conn mesosConn, spec MatchSpec) ([]MesosSlave, bool) {
+	// check for a maximum of 5 mins to see if matching agents have registered
 	// Krish's NOTE: docker pull is slow sometimes in the cloud
-	var isRegistered bool
-	stateChan := make(chan bool, 1)
+	var matched []MesosSlave
+	var found bool
+	stateChan := make(chan []MesosSlave, 1)
 	doneChan := make(chan bool, 1)
 
-	isRegistered = false
-	mesosHostPort := fmt.Sprintf("%s:%s", mip, mport)
+	start := time.Now()
 
-	go asyncQueryRegistration(mesosHostPort, mapi, sip, stateChan, doneChan)
+	go asyncQueryRegistration(detector, mapi, legacyAPI, conn, spec, stateChan, doneChan)
 
 	select {
-	case isRegistered = <-stateChan:
-		fmt.Printf("Found a slave registered with IP: '%s'\n", sip)
+	case matched = <-stateChan:
+		found = true
+		log.Info().Int("matched", len(matched)).Msg("match spec satisfied")
 	case <-time.After(time.Second * 90):
-		fmt.Printf("Couldn't find mesos slave after '%s' seconds\n", "90")
+		log.Warn().Str("timeout", "90s").Msg("match spec not satisfied before timeout")
 	}
+	agentRegistrationWaitSeconds.Observe(time.Since(start).Seconds())
 	doneChan <- true
 	close(doneChan)
-	return isRegistered
+	return matched, found
+}
+
+// buildLeaderDetector picks the ZK-backed LeaderDetector when --zk is set,
+// otherwise falls back to a plain seed list built from --seeds (or, absent
+// that, the single --mip/--mport pair).
+func buildLeaderDetector(mip, mport, zkURL, seeds string) (LeaderDetector, error) {
+	if zkURL != "" {
+		return newZKLeaderDetector(zkURL)
+	}
+	var seedList []string
+	if seeds != "" {
+		seedList = strings.Split(seeds, ",")
+	} else {
+		seedList = []string{fmt.Sprintf("%s:%s", mip, mport)}
+	}
+	return newSeedListDetector(seedList), nil
 }
 
 func main() {
-	var mip, mport, mapi, sip string
+	var mip, mport, mapi, sip, zkURL, seeds, serveAddr, metricsAddr string
+	var matchAttr, matchHostnameRegex, matchPublicIPCIDR string
+	var matchCount int
+	var matchAny, jsonOutput bool
+	var legacyAPI bool
+	var authCfg AuthConfig
+	flag.StringVar(&authCfg.Principal, "auth-principal", "",
+		"principal to authenticate to the mesos master as via SASL; disabled if empty")
+	flag.StringVar(&authCfg.Secret, "auth-secret", "",
+		"secret used alongside --auth-principal for the SASL handshake")
+	flag.StringVar(&authCfg.Provider, "auth-provider", "CRAM-MD5",
+		"SASL provider to authenticate with; Eg. 'CRAM-MD5'")
+	flag.StringVar(&authCfg.CACertPath, "ca-cert", "",
+		"PEM CA bundle to verify the master's certificate against; enables TLS")
+	flag.StringVar(&authCfg.ClientCertPath, "client-cert", "",
+		"PEM client certificate for mutual TLS; enables TLS")
+	flag.StringVar(&authCfg.ClientKeyPath, "client-key", "",
+		"PEM key matching --client-cert")
+	flag.StringVar(&authCfg.ServerName, "server-name", "",
+		"expected TLS server name on the master's certificate; enables TLS")
+	flag.BoolVar(&authCfg.InsecureSkipVerify, "insecure-skip-verify", false,
+		"skip TLS certificate verification; enables TLS")
 	flag.StringVar(&mip, "mip", "",
 		"mesos instance ip; Eg. 172.x.y.z; does not need to be mesos cluster leader")
 	flag.StringVar(&mport, "mport", "5050", "mesos instance port; Eg. 5050")
 	flag.StringVar(&mapi, "mapi", "/state",
-		"url path to use for querying mesos state; Eg. '/state'")
+		"url path to use for querying mesos state under --legacy-api; Eg. '/state'")
 	flag.StringVar(&sip, "sip", "",
-		"slave private ip to wait for; Eg. 172.x.y.z")
+		"slave private ip to wait for; Eg. 172.x.y.z; shorthand for --match-attr privateip=<sip>")
+	flag.StringVar(&matchAttr, "match-attr", "",
+		"comma-separated key=value attribute selectors to match agents against; Eg. 'rack=us-east-1a,instance_type=m5.large'")
+	flag.StringVar(&matchHostnameRegex, "match-hostname-regex", "",
+		"regular expression the agent hostname must match")
+	flag.StringVar(&matchPublicIPCIDR, "match-public-ip-cidr", "",
+		"CIDR the agent public IP must fall within; Eg. '10.0.0.0/8'")
+	flag.IntVar(&matchCount, "match-count", 1,
+		"number of agents that must match before the wait is satisfied")
+	flag.BoolVar(&matchAny, "match-any", false,
+		"match an agent if any selector matches, instead of requiring all of them (--match-all, the default)")
+	flag.BoolVar(&jsonOutput, "json", false,
+		"print the matched agents as JSON instead of a plain Slave Exists line")
+	flag.StringVar(&zkURL, "zk", "",
+		"zookeeper connection string to use for leader detection instead of --mip/--mport; Eg. zk://host1:2181,host2:2181/mesos")
+	flag.StringVar(&seeds, "seeds", "",
+		"comma-separated list of mesos master host:port seeds to fall back across; Eg. 172.31.1.1:5050,172.31.1.2:5050")
+	flag.BoolVar(&legacyAPI, "legacy-api", false,
+		"query the deprecated /state endpoint instead of the v1 Operator HTTP API")
+	flag.StringVar(&serveAddr, "serve", "",
+		"run as a long-lived HTTP daemon listening on this address (eg. ':8080') instead of a one-shot wait")
+	flag.StringVar(&metricsAddr, "metrics-addr", "",
+		"expose Prometheus metrics on this address (eg. ':9090'); disabled if empty")
 	flag.Parse()
+	initLogging()
 	// TODO(Krish): args sanity!
-	slaveExists := waitForMesosSlaveRegistration(mip, mport, mapi, sip)
-	fmt.Println("Slave Exists: ", slaveExists)
+	if metricsAddr != "" {
+		go func() {
+			if err := serveMetrics(metricsAddr); err != nil {
+				log.Error().Err(err).Msg("metrics server exited")
+			}
+		}()
+	}
+	httpClient, err := NewMesosHTTPClient(authCfg)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to build mesos http client")
+		os.Exit(1)
+	}
+	conn := mesosConn{httpClient: httpClient, scheme: authCfg.Scheme()}
+
+	detector, err := buildLeaderDetector(mip, mport, zkURL, seeds)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to build mesos leader detector")
+		os.Exit(1)
+	}
+	defer detector.Close()
+
+	if serveAddr != "" {
+		cache := newStateCache(detector, mapi, legacyAPI, conn)
+		go cache.run(stateCacheRefreshInterval)
+		log.Info().Str("addr", serveAddr).Msg("serving mesos cluster state")
+		if err := serveMesosState(serveAddr, cache); err != nil {
+			log.Error().Err(err).Msg("serve exited")
+			os.Exit(1)
+		}
+		return
+	}
+
+	spec, err := buildMatchSpec(sip, matchAttr, matchHostnameRegex, matchPublicIPCIDR, matchCount, matchAny)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid match selectors")
+		os.Exit(1)
+	}
+
+	matched, found := waitForMesosSlaveRegistration(detector, mapi, legacyAPI, conn, spec)
+	log.Info().Bool("slave_exists", found).Int("matched", len(matched)).Msg("registration wait complete")
+	if jsonOutput {
+		if err := json.NewEncoder(os.Stdout).Encode(matched); err != nil {
+			log.Error().Err(err).Msg("failed to encode matched agents as JSON")
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Println("Slave Exists: ", found)
 }
 
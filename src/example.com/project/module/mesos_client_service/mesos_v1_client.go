@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CallType enumerates the v1 Operator API Call messages this client knows
+// how to issue. Eg. {"type": "GET_AGENTS"}
+type CallType string
+
+const (
+	CallGetAgents CallType = "GET_AGENTS"
+	CallGetMaster CallType = "GET_MASTER"
+	CallGetTasks  CallType = "GET_TASKS"
+	CallSubscribe CallType = "SUBSCRIBE"
+)
+
+// Call is the envelope every request to /api/v1 is wrapped in.
+type Call struct {
+	Type CallType `json:"type"`
+}
+
+// v1Attribute mirrors the {name, type, text: {value}} shape mesos uses for
+// agent attributes in the v1 API, as opposed to the flat map the legacy
+// /state endpoint returned.
+type v1Attribute struct {
+	Name string `json:"name"`
+	Text struct {
+		Value string `json:"value"`
+	} `json:"text"`
+}
+
+type v1AgentInfo struct {
+	Hostname   string        `json:"hostname"`
+	Attributes []v1Attribute `json:"attributes,omitempty"`
+}
+
+type v1Agent struct {
+	AgentInfo      v1AgentInfo `json:"agent_info"`
+	Active         bool        `json:"active"`
+	Pid            string      `json:"pid"`
+	RegisteredTime float64     `json:"registered_time,omitempty"`
+}
+
+type v1MasterInfo struct {
+	Pid     string `json:"pid"`
+	Address struct {
+		Hostname string `json:"hostname"`
+		Port     int    `json:"port"`
+	} `json:"address"`
+}
+
+type v1Task struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// Response is the envelope /api/v1 wraps every Call's result in. Only the
+// field matching the request's Type is populated.
+type Response struct {
+	Type      string `json:"type"`
+	GetAgents *struct {
+		Agents []v1Agent `json:"agents"`
+	} `json:"get_agents,omitempty"`
+	GetMaster *struct {
+		MasterInfo v1MasterInfo `json:"master_info"`
+	} `json:"get_master,omitempty"`
+	GetTasks *struct {
+		Tasks []v1Task `json:"tasks"`
+	} `json:"get_tasks,omitempty"`
+}
+
+// Event is a single message delivered over a SUBSCRIBE stream.
+type Event struct {
+	Type       string `json:"type"`
+	AgentAdded *struct {
+		Agent v1Agent `json:"agent"`
+	} `json:"agent_added,omitempty"`
+	AgentRemoved *struct {
+		AgentID struct {
+			Value string `json:"value"`
+		} `json:"agent_id"`
+	} `json:"agent_removed,omitempty"`
+}
+
+// MesosClient speaks the versioned v1 Operator HTTP API
+// (http://<host>:<port>/api/v1). Non-leading masters 307-redirect API
+// calls to the current leader, which net/http follows transparently, so
+// unlike the legacy /state path this client never needs to chase the
+// 'leader' field itself.
+type MesosClient struct {
+	hostPort   string
+	scheme     string
+	httpClient *http.Client
+}
+
+// NewMesosClient builds a MesosClient targeting the given mesos
+// host:port, eg. "172.31.43.147:5050", sharing conn's http.Client (and
+// therefore its TLS/SASL configuration and connection pool).
+func NewMesosClient(hostPort string, conn mesosConn) *MesosClient {
+	return &MesosClient{
+		hostPort:   hostPort,
+		scheme:     conn.scheme,
+		httpClient: conn.httpClient,
+	}
+}
+
+func newMesosTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 300 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+func (c *MesosClient) call(callType CallType) (*Response, error) {
+	body, err := json.Marshal(Call{Type: callType})
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s://%s/api/v1", c.scheme, c.hostPort)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	httpResp, err := c.httpClient.Do(httpReq)
+	stateRequestDuration.WithLabelValues(c.hostPort).Observe(time.Since(start).Seconds())
+	if err != nil {
+		stateRequestsTotal.WithLabelValues("error", c.hostPort).Inc()
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		stateRequestsTotal.WithLabelValues("error", c.hostPort).Inc()
+		return nil, fmt.Errorf("%s call failed: %s", callType, httpResp.Status)
+	}
+	resp := new(Response)
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		stateRequestsTotal.WithLabelValues("error", c.hostPort).Inc()
+		return nil, err
+	}
+	stateRequestsTotal.WithLabelValues("success", c.hostPort).Inc()
+	log.Debug().Str("call", string(callType)).Str("master", c.hostPort).Msg("mesos v1 call succeeded")
+	return resp, nil
+}
+
+// GetAgents fetches the current agent list and maps it onto the same
+// MesosState/MesosSlave shape the rest of the tool operates on.
+func (c *MesosClient) GetAgents() (*MesosState, error) {
+	resp, err := c.call(CallGetAgents)
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetAgents == nil {
+		return nil, fmt.Errorf("GET_AGENTS response missing get_agents field")
+	}
+	state := &MesosState{}
+	for _, agent := range resp.GetAgents.Agents {
+		state.Slaves = append(state.Slaves, agentToSlave(agent))
+	}
+	return state, nil
+}
+
+// GetMaster fetches the elected master's identity.
+func (c *MesosClient) GetMaster() (*MesosState, error) {
+	resp, err := c.call(CallGetMaster)
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetMaster == nil {
+		return nil, fmt.Errorf("GET_MASTER response missing get_master field")
+	}
+	pid := resp.GetMaster.MasterInfo.Pid
+	return &MesosState{Leader: pid, Pid: pid}, nil
+}
+
+// GetTasks fetches the raw task list known to the master.
+func (c *MesosClient) GetTasks() ([]v1Task, error) {
+	resp, err := c.call(CallGetTasks)
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetTasks == nil {
+		return nil, fmt.Errorf("GET_TASKS response missing get_tasks field")
+	}
+	return resp.GetTasks.Tasks, nil
+}
+
+// Subscribe opens a persistent recordio-framed streaming connection and
+// delivers each Event (eg. AGENT_ADDED/AGENT_REMOVED) as it arrives. The
+// returned channel is closed when the connection ends.
+func (c *MesosClient) Subscribe() (<-chan Event, error) {
+	body, err := json.Marshal(Call{Type: CallSubscribe})
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s://%s/api/v1", c.scheme, c.hostPort)
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		httpResp.Body.Close()
+		return nil, fmt.Errorf("subscribe failed: %s", httpResp.Status)
+	}
+
+	events := make(chan Event, 16)
+	go func() {
+		defer httpResp.Body.Close()
+		defer close(events)
+		reader := bufio.NewReader(httpResp.Body)
+		for {
+			sizeLine, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			size, err := strconv.Atoi(strings.TrimSpace(sizeLine))
+			if err != nil {
+				continue
+			}
+			record := make([]byte, size)
+			if _, err := io.ReadFull(reader, record); err != nil {
+				return
+			}
+			var event Event
+			if err := json.Unmarshal(record, &event); err != nil {
+				continue
+			}
+			events <- event
+		}
+	}()
+	return events, nil
+}
+
+// watchAgentEvents opens a v1 Operator API SUBSCRIBE stream against the
+// current leader and pushes to notify every time an AGENT_ADDED/AGENT_REMOVED
+// event arrives, so callers can react to agent churn immediately instead of
+// waiting for their next poll tick. It reconnects (with a short backoff)
+// whenever the stream drops or the leader can't be resolved, and is a no-op
+// under --legacy-api, which has no v1 endpoint to subscribe to.
+func watchAgentEvents(detector LeaderDetector, legacyAPI bool, conn mesosConn, notify chan<- struct{}) {
+	if legacyAPI {
+		return
+	}
+	for {
+		hostPort, err := detector.Leader()
+		if err != nil {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		client := NewMesosClient(hostPort, conn)
+		events, err := client.Subscribe()
+		if err != nil {
+			log.Error().Err(err).Str("master", hostPort).Msg("failed to subscribe to mesos v1 event stream")
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for event := range events {
+			switch event.Type {
+			case "AGENT_ADDED", "AGENT_REMOVED":
+				select {
+				case notify <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// agentToSlave maps a v1 GET_AGENTS entry onto the legacy MesosSlave shape,
+// pulling the privateip/publicip/rack/instance_type attributes out of the
+// v1 name/text attribute list (see the coreos_user_data cloud-config that
+// sets them).
+func agentToSlave(agent v1Agent) MesosSlave {
+	slave := MesosSlave{
+		IsActive:       agent.Active,
+		MesosPid:       agent.Pid,
+		RegisteredTime: agent.RegisteredTime,
+	}
+	slave.Attributes.Host = agent.AgentInfo.Hostname
+	for _, attr := range agent.AgentInfo.Attributes {
+		switch attr.Name {
+		case "privateip":
+			slave.Attributes.PrivateIP = attr.Text.Value
+		case "publicip":
+			slave.Attributes.PublicIP = attr.Text.Value
+		case "rack":
+			slave.Attributes.Rack = attr.Text.Value
+		case "instance_type":
+			slave.Attributes.InstanceType = attr.Text.Value
+		}
+	}
+	return slave
+}
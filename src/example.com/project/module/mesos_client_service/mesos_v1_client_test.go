@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestAgentToSlave(t *testing.T) {
+	agent := v1Agent{
+		AgentInfo: v1AgentInfo{
+			Hostname: "agent-1.example.com",
+			Attributes: []v1Attribute{
+				{Name: "privateip", Text: struct {
+					Value string `json:"value"`
+				}{Value: "172.31.34.94"}},
+				{Name: "publicip", Text: struct {
+					Value string `json:"value"`
+				}{Value: "52.1.2.3"}},
+				{Name: "rack", Text: struct {
+					Value string `json:"value"`
+				}{Value: "us-east-1a"}},
+				{Name: "instance_type", Text: struct {
+					Value string `json:"value"`
+				}{Value: "m5.large"}},
+				{Name: "unrelated", Text: struct {
+					Value string `json:"value"`
+				}{Value: "ignored"}},
+			},
+		},
+		Active:         true,
+		Pid:            "slave(1)@172.31.34.94:5051",
+		RegisteredTime: 1458344004.38701,
+	}
+
+	slave := agentToSlave(agent)
+
+	if !slave.IsActive {
+		t.Error("IsActive = false, want true")
+	}
+	if slave.MesosPid != agent.Pid {
+		t.Errorf("MesosPid = %q, want %q", slave.MesosPid, agent.Pid)
+	}
+	if slave.RegisteredTime != agent.RegisteredTime {
+		t.Errorf("RegisteredTime = %v, want %v", slave.RegisteredTime, agent.RegisteredTime)
+	}
+	if slave.Attributes.Host != "agent-1.example.com" {
+		t.Errorf("Attributes.Host = %q, want %q", slave.Attributes.Host, "agent-1.example.com")
+	}
+	if slave.Attributes.PrivateIP != "172.31.34.94" {
+		t.Errorf("Attributes.PrivateIP = %q, want %q", slave.Attributes.PrivateIP, "172.31.34.94")
+	}
+	if slave.Attributes.PublicIP != "52.1.2.3" {
+		t.Errorf("Attributes.PublicIP = %q, want %q", slave.Attributes.PublicIP, "52.1.2.3")
+	}
+	if slave.Attributes.Rack != "us-east-1a" {
+		t.Errorf("Attributes.Rack = %q, want %q", slave.Attributes.Rack, "us-east-1a")
+	}
+	if slave.Attributes.InstanceType != "m5.large" {
+		t.Errorf("Attributes.InstanceType = %q, want %q", slave.Attributes.InstanceType, "m5.large")
+	}
+}
+
+func TestAgentToSlaveNoAttributes(t *testing.T) {
+	agent := v1Agent{AgentInfo: v1AgentInfo{Hostname: "agent-2.example.com"}}
+	slave := agentToSlave(agent)
+	if slave.Attributes.Host != "agent-2.example.com" {
+		t.Errorf("Attributes.Host = %q, want %q", slave.Attributes.Host, "agent-2.example.com")
+	}
+	if slave.Attributes.PrivateIP != "" {
+		t.Errorf("Attributes.PrivateIP = %q, want empty", slave.Attributes.PrivateIP)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Prometheus metrics for the mesos state requests this tool makes and the
+// registration waits it drives. "leader" labels the master endpoint that
+// was queried, so a fleet of these processes can be compared for per-master
+// latency skew.
+var (
+	stateRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mesos_state_requests_total",
+		Help: "Total mesos state requests, labeled by result and the master endpoint queried.",
+	}, []string{"result", "leader"})
+
+	stateRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "mesos_state_request_duration_seconds",
+		Help: "Latency of mesos state requests, labeled by the master endpoint queried.",
+	}, []string{"leader"})
+
+	agentRegistrationWaitSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "mesos_agent_registration_wait_seconds",
+		Help: "Time spent waiting for an agent to register with the cluster.",
+	})
+
+	agentsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mesos_agents_active",
+		Help: "Number of active agents in the most recently fetched cluster state.",
+	})
+
+	leaderChangesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mesos_leader_changes_total",
+		Help: "Number of times the detected mesos leader has changed.",
+	})
+)
+
+// initLogging points the global zerolog logger at a human-readable console
+// writer; set ZEROLOG_JSON=1 to keep the default JSON output instead, eg.
+// when shipping logs to a collector.
+func initLogging() {
+	if os.Getenv("ZEROLOG_JSON") != "" {
+		return
+	}
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+}
+
+// serveMetrics exposes the Prometheus metrics above on /metrics.
+func serveMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// stateCacheRefreshInterval is how often the background goroutine refreshes
+// the shared cluster state snapshot while serving.
+const stateCacheRefreshInterval = 15 * time.Second
+
+// webhookWatch is a registered POST /watch subscription: url is called
+// whenever ip's presence in the cluster flips.
+type webhookWatch struct {
+	ip          string
+	url         string
+	initialized bool
+	lastPresent bool
+}
+
+// stateCache holds a single cached MesosState refreshed by one background
+// goroutine, so --serve mode can answer many concurrent requests without
+// hammering the mesos master once per request.
+type stateCache struct {
+	detector         LeaderDetector
+	mesosApiEndpoint string
+	legacyAPI        bool
+	conn             mesosConn
+
+	mu    sync.RWMutex
+	state *MesosState
+
+	watchMu sync.Mutex
+	watches []*webhookWatch
+}
+
+func newStateCache(detector LeaderDetector, mesosApiEndpoint string, legacyAPI bool, conn mesosConn) *stateCache {
+	return &stateCache{
+		detector:         detector,
+		mesosApiEndpoint: mesosApiEndpoint,
+		legacyAPI:        legacyAPI,
+		conn:             conn,
+	}
+}
+
+// Snapshot returns the most recently cached state, or nil if no refresh has
+// completed yet.
+func (c *stateCache) Snapshot() *MesosState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// FindByIP looks up an agent by private IP in the cached state.
+func (c *stateCache) FindByIP(ip string) (*MesosSlave, bool) {
+	state := c.Snapshot()
+	if state == nil {
+		return nil, false
+	}
+	for i := range state.Slaves {
+		if state.Slaves[i].Attributes.PrivateIP == ip {
+			return &state.Slaves[i], true
+		}
+	}
+	return nil, false
+}
+
+// AddWatch registers a webhook to be POSTed to whenever ip appears in or
+// disappears from the cluster.
+func (c *stateCache) AddWatch(ip, url string) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	c.watches = append(c.watches, &webhookWatch{ip: ip, url: url})
+}
+
+func (c *stateCache) refresh() error {
+	hostPort, err := c.detector.Leader()
+	if err != nil {
+		return err
+	}
+	state, err := fetchMesosState(hostPort, c.mesosApiEndpoint, c.legacyAPI, c.conn)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.state = state
+	c.mu.Unlock()
+	c.notifyWatches(state)
+	return nil
+}
+
+func (c *stateCache) notifyWatches(state *MesosState) {
+	present := make(map[string]bool, len(state.Slaves))
+	for _, slave := range state.Slaves {
+		present[slave.Attributes.PrivateIP] = true
+	}
+	c.watchMu.Lock()
+	watches := append([]*webhookWatch(nil), c.watches...)
+	c.watchMu.Unlock()
+	for _, watch := range watches {
+		isPresent := present[watch.ip]
+		if !watch.initialized {
+			// first observation since the watch was registered: establish
+			// the baseline without firing, since we don't yet know whether
+			// this is a change
+			watch.initialized = true
+			watch.lastPresent = isPresent
+			continue
+		}
+		if watch.lastPresent == isPresent {
+			continue
+		}
+		watch.lastPresent = isPresent
+		go fireWebhook(watch.url, watch.ip, isPresent)
+	}
+}
+
+func fireWebhook(url, ip string, present bool) {
+	payload, err := json.Marshal(struct {
+		IP      string `json:"ip"`
+		Present bool   `json:"present"`
+	}{ip, present})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal webhook payload")
+		return
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Error().Err(err).Str("url", url).Msg("failed to fire webhook")
+		return
+	}
+	resp.Body.Close()
+}
+
+// run refreshes the cache every interval, or immediately when the
+// LeaderDetector reports a leader change or the v1 SUBSCRIBE stream reports
+// an agent was added/removed.
+func (c *stateCache) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	agentEvents := make(chan struct{}, 1)
+	go watchAgentEvents(c.detector, c.legacyAPI, c.conn, agentEvents)
+	for {
+		if err := c.refresh(); err != nil {
+			log.Error().Err(err).Msg("failed to refresh mesos state cache")
+		}
+		select {
+		case <-c.detector.LeaderChanged():
+		case <-agentEvents:
+		case <-ticker.C:
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (c *stateCache) handleAgents(w http.ResponseWriter, r *http.Request) {
+	state := c.Snapshot()
+	slaves := []MesosSlave{}
+	if state != nil {
+		slaves = state.Slaves
+	}
+	writeJSON(w, slaves)
+}
+
+func (c *stateCache) handleAgentByIP(w http.ResponseWriter, r *http.Request) {
+	ip := strings.TrimPrefix(r.URL.Path, "/agents/")
+	slave, ok := c.FindByIP(ip)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, slave)
+}
+
+func (c *stateCache) handleLeader(w http.ResponseWriter, r *http.Request) {
+	state := c.Snapshot()
+	if state == nil {
+		http.Error(w, "cluster state not yet available", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, struct {
+		Leader string `json:"leader"`
+	}{state.Leader})
+}
+
+func (c *stateCache) handleWait(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ip := r.URL.Query().Get("ip")
+	if ip == "" {
+		http.Error(w, "ip is required", http.StatusBadRequest)
+		return
+	}
+	timeout := 90 * time.Second
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		parsed, err := time.ParseDuration(t)
+		if err != nil {
+			http.Error(w, "invalid timeout: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if _, ok := c.FindByIP(ip); ok {
+			writeJSON(w, struct {
+				Registered bool `json:"registered"`
+			}{true})
+			return
+		}
+		select {
+		case <-deadline:
+			writeJSON(w, struct {
+				Registered bool `json:"registered"`
+			}{false})
+			return
+		case <-ticker.C:
+			continue
+		}
+	}
+}
+
+func (c *stateCache) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ip := r.URL.Query().Get("ip")
+	hookURL := r.URL.Query().Get("url")
+	if ip == "" || hookURL == "" {
+		http.Error(w, "ip and url are required", http.StatusBadRequest)
+		return
+	}
+	c.AddWatch(ip, hookURL)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveMesosState runs the long-lived HTTP daemon exposing agent
+// registration as a REST/webhook service, backed by cache.
+func serveMesosState(addr string, cache *stateCache) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/agents", cache.handleAgents)
+	mux.HandleFunc("/agents/", cache.handleAgentByIP)
+	mux.HandleFunc("/leader", cache.handleLeader)
+	mux.HandleFunc("/wait", cache.handleWait)
+	mux.HandleFunc("/watch", cache.handleWatch)
+	return http.ListenAndServe(addr, mux)
+}